@@ -0,0 +1,101 @@
+// Package config holds the types parsed from mort's YAML configuration,
+// shared by pkg/object and pkg/storage.
+package config
+
+import "time"
+
+// Storage describes how to reach one storage backend (local disk, S3, an
+// HTTP origin, ...). obj.Storage on object.FileObject is a Storage.
+type Storage struct {
+	// Kind selects the backend: "local", "local-meta", "http", "s3", "b2",
+	// "swift" or "gcs".
+	Kind string
+
+	// Bucket overrides the bucket/container name; when empty the
+	// object's own bucket is used. For "swift", a non-empty Container
+	// takes precedence over both.
+	Bucket string
+
+	// PathPrefix is prepended to every object key for this storage.
+	PathPrefix string
+
+	// Headers are sent with every request for the "http" backend.
+	Headers map[string]string
+
+	// RootPath is the base directory for "local" and "local-meta".
+	RootPath string
+
+	// Url is the origin base URL for the "http" backend.
+	Url string
+
+	// AccessKey, SecretAccessKey, Region and Endpoint configure the "s3"
+	// backend.
+	AccessKey       string
+	SecretAccessKey string
+	Region          string
+	Endpoint        string
+
+	// Account and Key are the B2 account ID and application key for the
+	// "b2" backend.
+	Account string
+	Key     string
+
+	// AuthURL, Username, Password, Tenant, TenantID, Domain, DomainID,
+	// Container and InsecureSkipVerify configure the "swift" backend.
+	AuthURL            string
+	Username           string
+	Password           string
+	Tenant             string
+	TenantID           string
+	Domain             string
+	DomainID           string
+	Container          string
+	InsecureSkipVerify bool
+
+	// ProjectID, CredentialsJSON and CredentialsFile configure the "gcs"
+	// backend. When both CredentialsJSON and CredentialsFile are empty,
+	// Application Default Credentials are used.
+	ProjectID       string
+	CredentialsJSON string
+	CredentialsFile string
+
+	// Hash uniquely identifies this storage configuration and is used as
+	// the containerCache key.
+	Hash string
+
+	// Redirect configures presigned-URL/redirect mode for pass-through
+	// requests to this storage, instead of mort streaming the body itself.
+	Redirect Redirect
+
+	// MultipartCopyThresholdSize is the source object size at or above which Copy
+	// uses parallel part-copies instead of a single native copy call.
+	// Zero uses the package default.
+	MultipartCopyThresholdSize int64
+
+	// MultipartCopyMaxConcurrency bounds how many part-copies Copy runs at
+	// once for this storage. Zero uses the package default.
+	MultipartCopyMaxConcurrency int
+}
+
+// Redirect configures per-storage presigned-URL redirect mode.
+type Redirect struct {
+	// Enabled turns redirect mode on for this storage.
+	Enabled bool
+
+	// Methods lists the HTTP methods (e.g. "GET", "HEAD") eligible for a
+	// redirect; any other method always falls through to streaming.
+	Methods []string
+
+	// Expiry is how long the presigned URL stays valid.
+	Expiry time.Duration
+}
+
+// Server holds mort's top-level, non-per-storage configuration.
+type Server struct {
+	// ContainerCacheSize bounds storage.containerCache, the process-wide
+	// cache of dialed stow.Container handles shared across every Storage.
+	// Zero uses the package's own default. The server bootstrap should
+	// pass this to storage.SetContainerCacheSize on startup and on config
+	// reload.
+	ContainerCacheSize int
+}
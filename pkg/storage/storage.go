@@ -1,6 +1,9 @@
 package storage
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"github.com/aldor007/stow"
 	httpStorage "github.com/aldor007/stow/http"
@@ -8,17 +11,22 @@ import (
 	metaStorage "github.com/aldor007/stow/local-meta"
 	// import blank to register noop adapter in stow.Register
 	"encoding/xml"
+	"fmt"
 	"github.com/aldor007/mort/pkg/monitoring"
 	"github.com/aldor007/mort/pkg/object"
 	"github.com/aldor007/mort/pkg/response"
 	b2Storage "github.com/aldor007/stow/b2"
+	gcsStorage "github.com/aldor007/stow/google"
 	_ "github.com/aldor007/stow/noop"
 	s3Storage "github.com/aldor007/stow/s3"
+	swiftStorage "github.com/aldor007/stow/swift"
+	"github.com/bluele/gcache"
 	"go.uber.org/zap"
 	"io"
 	"mime"
 	"net/http"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -26,17 +34,125 @@ import (
 
 const notFound = "{\"error\":\"item not found\"}"
 
-// storageCache map for used storage client instances
-var storageCache = make(map[string]stow.Container)
+// defaultContainerCacheSize is used until SetContainerCacheSize is called
+// with a value read from pkg/config.
+const defaultContainerCacheSize = 1000
 
-// storageCacheLock lock for writing to storageCache
-var storageCacheLock = sync.RWMutex{}
+// containerCacheTTL is how long a successfully dialed container is kept.
+const containerCacheTTL = 15 * time.Minute
 
-// Get retrieve obj from given storage and returns its wrapped in response
-func Get(obj *object.FileObject) *response.Response {
+// notFoundCacheTTL is how long a failed Dial/Container lookup is remembered
+// so repeated misses don't hammer a misconfigured or unreachable backend;
+// the entry is evicted after this window so the next request retries.
+const notFoundCacheTTL = 30 * time.Second
+
+// containerCacheEntry is the value stored in containerCache. err is set for
+// negative entries (e.g. stow.ErrNotFound) so a prior dial failure can be
+// served from cache without redialing on every request.
+type containerCacheEntry struct {
+	container stow.Container
+	err       error
+}
+
+// containerCacheSweepInterval is how often the background sweeper purges
+// expired containerCache entries, so a backend that stops being queried
+// doesn't keep its (possibly negative) entry around until the next Get.
+const containerCacheSweepInterval = 1 * time.Minute
+
+// containerCacheMu guards containerCache: SetContainerCacheSize can replace
+// the cache from a config-reload goroutine while getClient reads it on
+// every request, so plain reads/writes of the var would race.
+var containerCacheMu sync.RWMutex
+
+// containerCache replaces the old unbounded storageCache map with a
+// size-bounded, TTL-expiring LRU so stale or failed container handles don't
+// accumulate forever and transient backend errors get retried. Guard all
+// access through getContainerCache/SetContainerCacheSize.
+var containerCache = newContainerCache(defaultContainerCacheSize)
+
+// newContainerCache builds a container cache of the given size, wiring its
+// EvictedFunc to the storage_container_cache_evict metric so that metric
+// reflects actual evictions (TTL expiry or LRU capacity pressure), not
+// every negative-cache write.
+func newContainerCache(size int) gcache.Cache {
+	return gcache.New(size).LRU().
+		EvictedFunc(func(key, value interface{}) {
+			monitoring.Report().Inc("storage_container_cache_evict")
+		}).
+		Build()
+}
+
+// getContainerCache returns the current container cache.
+func getContainerCache() gcache.Cache {
+	containerCacheMu.RLock()
+	defer containerCacheMu.RUnlock()
+	return containerCache
+}
+
+// SetContainerCacheSize resizes the container cache to the given size
+// (config.Server.ContainerCacheSize); a size <= 0 selects
+// defaultContainerCacheSize. Existing entries are dropped since gcache
+// caches can't be resized in place.
+func SetContainerCacheSize(size int) {
+	if size <= 0 {
+		size = defaultContainerCacheSize
+	}
+	containerCacheMu.Lock()
+	containerCache = newContainerCache(size)
+	containerCacheMu.Unlock()
+}
+
+// sweepContainerCache runs for the lifetime of the process, periodically
+// purging expired containerCache entries in the background so TTLs are
+// enforced even for backends that have gone idle.
+func sweepContainerCache() {
+	ticker := time.NewTicker(containerCacheSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		purgeExpiredContainerCache()
+	}
+}
+
+// purgeExpiredContainerCache removes every containerCache entry whose TTL
+// has passed. gcache only expires an entry lazily inside Get/GetIFPresent,
+// and GetALL(true) merely returns a filtered snapshot without removing
+// anything -- neither actually frees an idle (possibly negative) entry, so
+// this diffs Keys(false) (every key) against Keys(true) (live keys) and
+// removes the rest.
+func purgeExpiredContainerCache() {
+	cache := getContainerCache()
+
+	live := make(map[interface{}]bool)
+	for _, key := range cache.Keys(true) {
+		live[key] = true
+	}
+
+	for _, key := range cache.Keys(false) {
+		if !live[key] {
+			cache.Remove(key)
+		}
+	}
+}
+
+func init() {
+	go sweepContainerCache()
+}
+
+// Get retrieve obj from given storage and returns its wrapped in response.
+// skipRedirect bypasses the storage's Redirect config even when enabled,
+// for callers (e.g. transform pipelines) that need the actual bytes rather
+// than a 307 pointing at the backend.
+func Get(obj *object.FileObject, skipRedirect bool) *response.Response {
 	metric := "storage_time;method:get,storage:" + obj.Storage.Kind
 	t := monitoring.Report().Timer(metric)
 	defer t.Done()
+
+	if !skipRedirect {
+		if res := Redirect(obj, http.MethodGet); res != nil {
+			return res
+		}
+	}
+
 	key := getKey(obj)
 	client, err := getClient(obj)
 	if err != nil {
@@ -69,11 +185,20 @@ func Get(obj *object.FileObject) *response.Response {
 	return res
 }
 
-// Head retrieve obj from given storage and returns its wrapped in response (but only headers, content of object is omitted)
-func Head(obj *object.FileObject) *response.Response {
+// Head retrieve obj from given storage and returns its wrapped in response
+// (but only headers, content of object is omitted). skipRedirect bypasses
+// the storage's Redirect config the same way it does for Get.
+func Head(obj *object.FileObject, skipRedirect bool) *response.Response {
 	metric := "storage_time;method:head,storage:" + obj.Storage.Kind
 	t := monitoring.Report().Timer(metric)
 	defer t.Done()
+
+	if !skipRedirect {
+		if res := Redirect(obj, http.MethodHead); res != nil {
+			return res
+		}
+	}
+
 	key := getKey(obj)
 	client, err := getClient(obj)
 	if err != nil {
@@ -139,7 +264,7 @@ func Delete(obj *object.FileObject) *response.Response {
 		return response.NewError(503, err)
 	}
 
-	resHead := Head(obj)
+	resHead := Head(obj, true)
 	if resHead.StatusCode == 200 {
 		err = client.RemoveItem(getKey(obj))
 
@@ -155,71 +280,535 @@ func Delete(obj *object.FileObject) *response.Response {
 	return resHead
 }
 
-// List returns list of object in given path in S3 format
-// nolint: gocyclo
-func List(obj *object.FileObject, maxKeys int, _ string, prefix string, marker string) *response.Response {
-	client, err := getClient(obj)
+// defaultMultipartCopyThresholdSize is used when
+// obj.Storage.MultipartCopyThresholdSize is unset (<= 0).
+const defaultMultipartCopyThresholdSize = 512 * 1024 * 1024
+
+// defaultMultipartCopyMaxConcurrency is used when
+// obj.Storage.MultipartCopyMaxConcurrency is unset (<= 0).
+const defaultMultipartCopyMaxConcurrency = 4
+
+// multipartCopyPartSize bounds how large a single copied part can be, so
+// part count scales with object size instead of always being
+// multipartCopyMaxConcurrency(dst) regardless of size.
+const multipartCopyPartSize = 128 * 1024 * 1024
+
+// multipartCopyThresholdSize returns dst's configured copy threshold, or
+// defaultMultipartCopyThresholdSize if unset.
+func multipartCopyThresholdSize(dst *object.FileObject) int64 {
+	if dst.Storage.MultipartCopyThresholdSize > 0 {
+		return dst.Storage.MultipartCopyThresholdSize
+	}
+	return defaultMultipartCopyThresholdSize
+}
+
+// multipartCopyMaxConcurrency returns dst's configured copy concurrency, or
+// defaultMultipartCopyMaxConcurrency if unset.
+func multipartCopyMaxConcurrency(dst *object.FileObject) int {
+	if dst.Storage.MultipartCopyMaxConcurrency > 0 {
+		return dst.Storage.MultipartCopyMaxConcurrency
+	}
+	return defaultMultipartCopyMaxConcurrency
+}
+
+// multipartUploadPrefix is where the portable multipart fallback (used by
+// backends without native multipart support) stages parts until Complete.
+const multipartUploadPrefix = ".mort-uploads/"
+
+// copier is implemented by stow containers that can perform a server-side
+// copy without streaming the body through mort. s3 and gcs implement it;
+// other backends fall back to a stream copy.
+type copier interface {
+	Copy(srcKey string, dstContainer string, dstKey string, metadata map[string]interface{}) error
+}
+
+// multipartCopier is implemented by stow containers that can copy an object
+// in parallel parts instead of a single request. s3 and gcs implement it.
+// It mirrors multipartUploader's initiate/part/complete/abort shape so a
+// copy, like an upload, is tied to one upload ID instead of letting each
+// concurrent CopyPart call implicitly start its own.
+type multipartCopier interface {
+	InitiateCopy(dstContainer string, dstKey string, metadata map[string]interface{}) (string, error)
+	CopyPart(uploadID string, srcKey string, dstContainer string, dstKey string, partNumber int, rangeStart int64, rangeEnd int64) (CompletedPart, error)
+	CompleteCopy(uploadID string, dstContainer string, dstKey string, parts []CompletedPart) error
+	AbortCopy(uploadID string, dstContainer string, dstKey string) error
+}
+
+// Copy copies src to dst, server-side when the backend supports it. Large
+// objects (>= MultipartCopyThresholdSize) are copied via parallel part-copies
+// on backends that support it (s3, gcs); everything else falls back to a
+// single native copy, or as a last resort a stream copy through mort.
+// metaDirective mirrors the S3 x-amz-metadata-directive header: "REPLACE"
+// replaces the destination's metadata with metaHeaders, "COPY" (the
+// default) inherits src's metadata and ignores metaHeaders. metaHeaders is
+// an explicit parameter rather than a field on object.FileObject, for the
+// same reason Set/InitiateMultipart/CompleteMultipart take one: request
+// headers aren't carried on the object.
+func Copy(dst, src *object.FileObject, metaDirective string, metaHeaders http.Header) *response.Response {
+	metric := "storage_time;method:copy,storage:" + dst.Storage.Kind
+	t := monitoring.Report().Timer(metric)
+	defer t.Done()
+
+	srcClient, err := getClient(src)
 	if err != nil {
-		monitoring.Log().Warn("Storage/List", obj.LogData(zap.Int("sc", 503), zap.Error(err))...)
+		monitoring.Log().Warn("Storage/Copy source client", src.LogData(zap.Int("sc", 503), zap.Error(err))...)
 		return response.NewError(503, err)
 	}
 
-	prefix = path.Join(obj.Storage.PathPrefix, prefix)
+	dstClient, err := getClient(dst)
+	if err != nil {
+		monitoring.Log().Warn("Storage/Copy destination client", dst.LogData(zap.Int("sc", 503), zap.Error(err))...)
+		return response.NewError(503, err)
+	}
 
-	if prefix != "" && prefix != "/" && obj.Storage.Kind == "local-meta" {
-		_, err = client.Item(prefix)
-		if err != nil {
-			if err == stow.ErrNotFound {
-				monitoring.Log().Info("Storage/List item not fountresponse", obj.LogData(zap.Int("sc", 404))...)
-				return response.NewString(404, obj.Key)
+	srcKey := getKey(src)
+	srcItem, err := srcClient.Item(srcKey)
+	if err != nil {
+		if err == stow.ErrNotFound {
+			return response.NewString(404, notFound)
+		}
+		monitoring.Log().Warn("Storage/Copy source item", src.LogData(zap.Error(err))...)
+		return response.NewError(500, err)
+	}
+
+	metadata, err := srcItem.Metadata()
+	if err != nil {
+		monitoring.Log().Warn("Storage/Copy source metadata", src.LogData(zap.Error(err))...)
+		return response.NewError(500, err)
+	}
+
+	if metaDirective == "REPLACE" {
+		metadata = prepareMetadata(dst, metaHeaders)
+	}
+
+	dstKey := getKey(dst)
+	dstContainer := getBucketName(dst)
+	size, _ := srcItem.Size()
+
+	if size >= multipartCopyThresholdSize(dst) {
+		if mc, ok := dstClient.(multipartCopier); ok {
+			uploadID, err := mc.InitiateCopy(dstContainer, dstKey, metadata)
+			if err != nil {
+				monitoring.Log().Warn("Storage/Copy initiate multipart copy", dst.LogData(zap.Error(err))...)
+				return response.NewError(500, err)
+			}
+
+			parts, err := copyInParts(mc, uploadID, srcKey, dstContainer, dstKey, size, multipartCopyMaxConcurrency(dst))
+			if err != nil {
+				monitoring.Log().Warn("Storage/Copy multipart copy", dst.LogData(zap.Error(err))...)
+				if abortErr := mc.AbortCopy(uploadID, dstContainer, dstKey); abortErr != nil {
+					monitoring.Log().Warn("Storage/Copy abort multipart copy", dst.LogData(zap.Error(abortErr))...)
+				}
+				return response.NewError(500, err)
+			}
+
+			if err := mc.CompleteCopy(uploadID, dstContainer, dstKey, parts); err != nil {
+				monitoring.Log().Warn("Storage/Copy complete multipart copy", dst.LogData(zap.Error(err))...)
+				return response.NewError(500, err)
 			}
+
+			return response.NewNoContent(200)
 		}
 	}
 
-	items, resultMarker, err := client.Items(prefix, marker, maxKeys)
+	if c, ok := dstClient.(copier); ok {
+		if err := c.Copy(srcKey, dstContainer, dstKey, metadata); err != nil {
+			monitoring.Log().Warn("Storage/Copy native copy", dst.LogData(zap.Error(err))...)
+			return response.NewError(500, err)
+		}
+
+		return response.NewNoContent(200)
+	}
+
+	reader, err := srcItem.Open()
 	if err != nil {
-		monitoring.Log().Warn("Storage/List", obj.LogData(zap.Int("sc", 500), zap.Error(err))...)
+		monitoring.Log().Warn("Storage/Copy open source item", src.LogData(zap.Error(err))...)
 		return response.NewError(500, err)
 	}
+	defer reader.Close()
 
-	type contentXML struct {
-		Key          string `xml:"Key"`
-		StorageClass string `xml:"StorageClass"`
-		LastModified string `xml:"LastModified"`
-		ETag         string `xml:"ETag"`
-		Size         int64  `xml:"Size"`
+	if _, err := dstClient.Put(dstKey, reader, size, metadata); err != nil {
+		monitoring.Log().Warn("Storage/Copy stream copy", dst.LogData(zap.Error(err))...)
+		return response.NewError(500, err)
 	}
 
-	type commonPrefixXML struct {
-		Prefix string `xml:"Prefix"`
+	return response.NewNoContent(200)
+}
+
+// copyInParts splits [0, size) into multipartCopyPartSize-sized ranges --
+// so part count scales with size instead of always equal to
+// maxConcurrency -- and copies up to maxConcurrency of them at a time
+// through mc, tagged with uploadID. It returns the completed parts in
+// order, ready to hand to mc.CompleteCopy.
+func copyInParts(mc multipartCopier, uploadID string, srcKey string, dstContainer string, dstKey string, size int64, maxConcurrency int) ([]CompletedPart, error) {
+	partSize := int64(multipartCopyPartSize)
+	if partSize > size {
+		partSize = size
+	}
+
+	numParts := int((size + partSize - 1) / partSize)
+	parts := make([]CompletedPart, numParts)
+	errs := make([]error, numParts)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxConcurrency)
+
+	i := 0
+	for rangeStart := int64(0); rangeStart < size; rangeStart += partSize {
+		rangeEnd := rangeStart + partSize - 1
+		if rangeEnd >= size-1 {
+			rangeEnd = size - 1
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, partNumber int, rangeStart, rangeEnd int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			part, err := mc.CopyPart(uploadID, srcKey, dstContainer, dstKey, partNumber, rangeStart, rangeEnd)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			parts[i] = part
+		}(i, i+1, rangeStart, rangeEnd)
+		i++
 	}
 
-	type listBucketResult struct {
-		XMLName        xml.Name          `xml:"ListBucketResult"`
-		Name           string            `xml:"Name"`
-		Prefix         string            `xml:"Prefix"`
-		Marker         string            `xml:"Marker"`
-		MaxKeys        int               `xml:"MaxKeys"`
-		IsTruncated    bool              `xml:"IsTruncated"`
-		Contents       []contentXML      `xml:"Contents"`
-		CommonPrefixes []commonPrefixXML `xml:"CommonPrefixes"`
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	result := listBucketResult{Name: obj.Bucket, Prefix: prefix, Marker: resultMarker, MaxKeys: maxKeys, IsTruncated: false}
+	return parts, nil
+}
+
+// CompletedPart is one uploaded or staged part of a multipart upload, as
+// returned by UploadPart and fed back into CompleteMultipart.
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+// multipartUploader is implemented by stow containers with native multipart
+// upload support (s3, gcs). Other backends use the fallback below, which
+// stages parts under multipartUploadPrefix and stitches them on Complete.
+type multipartUploader interface {
+	InitiateMultipart(key string, metadata map[string]interface{}) (string, error)
+	UploadPart(uploadID string, key string, partNumber int, body io.Reader, size int64) (CompletedPart, error)
+	CompleteMultipart(uploadID string, key string, parts []CompletedPart) error
+	AbortMultipart(uploadID string, key string) error
+}
+
+// InitiateMultipart starts a multipart upload for obj and returns an upload
+// ID that must be passed to UploadPart, CompleteMultipart and AbortMultipart.
+func InitiateMultipart(obj *object.FileObject, metaHeaders http.Header) (string, *response.Response) {
+	client, err := getClient(obj)
+	if err != nil {
+		monitoring.Log().Warn("Storage/InitiateMultipart create client", obj.LogData(zap.Int("sc", 503), zap.Error(err))...)
+		return "", response.NewError(503, err)
+	}
+
+	metadata := prepareMetadata(obj, metaHeaders)
+	if mu, ok := client.(multipartUploader); ok {
+		uploadID, err := mu.InitiateMultipart(getKey(obj), metadata)
+		if err != nil {
+			monitoring.Log().Warn("Storage/InitiateMultipart", obj.LogData(zap.Error(err))...)
+			return "", response.NewError(500, err)
+		}
+
+		return uploadID, nil
+	}
+
+	return newUploadID(), nil
+}
+
+// UploadPart uploads a single part of an in-progress multipart upload.
+func UploadPart(obj *object.FileObject, uploadID string, partNumber int, body io.Reader, size int64) (CompletedPart, *response.Response) {
+	client, err := getClient(obj)
+	if err != nil {
+		monitoring.Log().Warn("Storage/UploadPart create client", obj.LogData(zap.Int("sc", 503), zap.Error(err))...)
+		return CompletedPart{}, response.NewError(503, err)
+	}
+
+	key := getKey(obj)
+	if mu, ok := client.(multipartUploader); ok {
+		part, err := mu.UploadPart(uploadID, key, partNumber, body, size)
+		if err != nil {
+			monitoring.Log().Warn("Storage/UploadPart", obj.LogData(zap.Error(err))...)
+			return CompletedPart{}, response.NewError(500, err)
+		}
 
+		return part, nil
+	}
+
+	partKey, err := multipartPartKey(obj, uploadID, partNumber)
+	if err != nil {
+		monitoring.Log().Warn("Storage/UploadPart invalid uploadID", obj.LogData(zap.Error(err))...)
+		return CompletedPart{}, response.NewError(400, err)
+	}
+
+	item, err := client.Put(partKey, body, size, map[string]interface{}{})
+	if err != nil {
+		monitoring.Log().Warn("Storage/UploadPart stage part", obj.LogData(zap.Error(err))...)
+		return CompletedPart{}, response.NewError(500, err)
+	}
+
+	etag, _ := item.ETag()
+	return CompletedPart{PartNumber: partNumber, ETag: etag}, nil
+}
+
+// CompleteMultipart finishes an in-progress multipart upload, assembling obj
+// from the given parts (which must be ordered by PartNumber).
+func CompleteMultipart(obj *object.FileObject, uploadID string, parts []CompletedPart, metaHeaders http.Header) *response.Response {
+	client, err := getClient(obj)
+	if err != nil {
+		monitoring.Log().Warn("Storage/CompleteMultipart create client", obj.LogData(zap.Int("sc", 503), zap.Error(err))...)
+		return response.NewError(503, err)
+	}
+
+	key := getKey(obj)
+	if mu, ok := client.(multipartUploader); ok {
+		if err := mu.CompleteMultipart(uploadID, key, parts); err != nil {
+			monitoring.Log().Warn("Storage/CompleteMultipart", obj.LogData(zap.Error(err))...)
+			return response.NewError(500, err)
+		}
+
+		return response.NewNoContent(200)
+	}
+
+	readers := make([]io.Reader, 0, len(parts))
+	var totalSize int64
+	for _, part := range parts {
+		partKey, err := multipartPartKey(obj, uploadID, part.PartNumber)
+		if err != nil {
+			monitoring.Log().Warn("Storage/CompleteMultipart invalid uploadID", obj.LogData(zap.Error(err))...)
+			return response.NewError(400, err)
+		}
+
+		partItem, err := client.Item(partKey)
+		if err != nil {
+			monitoring.Log().Warn("Storage/CompleteMultipart missing part", obj.LogData(zap.Error(err))...)
+			return response.NewError(500, err)
+		}
+
+		reader, err := partItem.Open()
+		if err != nil {
+			monitoring.Log().Warn("Storage/CompleteMultipart open part", obj.LogData(zap.Error(err))...)
+			return response.NewError(500, err)
+		}
+		defer reader.Close()
+
+		size, _ := partItem.Size()
+		totalSize += size
+		readers = append(readers, reader)
+	}
+
+	if _, err := client.Put(key, io.MultiReader(readers...), totalSize, prepareMetadata(obj, metaHeaders)); err != nil {
+		monitoring.Log().Warn("Storage/CompleteMultipart assemble", obj.LogData(zap.Error(err))...)
+		return response.NewError(500, err)
+	}
+
+	for _, part := range parts {
+		if partKey, err := multipartPartKey(obj, uploadID, part.PartNumber); err == nil {
+			_ = client.RemoveItem(partKey)
+		}
+	}
+
+	return response.NewNoContent(200)
+}
+
+// AbortMultipart cancels an in-progress multipart upload. On the fallback
+// path it removes the staged parts listed in parts; staged parts not listed
+// there are left in place.
+func AbortMultipart(obj *object.FileObject, uploadID string, parts []CompletedPart) *response.Response {
+	client, err := getClient(obj)
+	if err != nil {
+		monitoring.Log().Warn("Storage/AbortMultipart create client", obj.LogData(zap.Int("sc", 503), zap.Error(err))...)
+		return response.NewError(503, err)
+	}
+
+	key := getKey(obj)
+	if mu, ok := client.(multipartUploader); ok {
+		if err := mu.AbortMultipart(uploadID, key); err != nil {
+			monitoring.Log().Warn("Storage/AbortMultipart", obj.LogData(zap.Error(err))...)
+			return response.NewError(500, err)
+		}
+
+		return response.NewNoContent(204)
+	}
+
+	for _, part := range parts {
+		if partKey, err := multipartPartKey(obj, uploadID, part.PartNumber); err == nil {
+			_ = client.RemoveItem(partKey)
+		}
+	}
+
+	return response.NewNoContent(204)
+}
+
+// multipartPartKey is the staging key used for a fallback multipart part,
+// nested under the directory of obj's key so it inherits obj.Storage.PathPrefix the same
+// way every other operation in this file does, rather than writing directly
+// under multipartUploadPrefix at the container root. uploadID is rejected
+// if it contains a path separator or "..", since it's echoed back by
+// whatever client called InitiateMultipart and nothing here can verify it
+// was actually issued for this obj.
+func multipartPartKey(obj *object.FileObject, uploadID string, partNumber int) (string, error) {
+	if uploadID == "" || strings.ContainsAny(uploadID, `/\`) || strings.Contains(uploadID, "..") {
+		return "", fmt.Errorf("storage: invalid uploadID %q", uploadID)
+	}
+
+	dir := path.Dir(getKey(obj))
+	return path.Join(dir, multipartUploadPrefix, uploadID, fmt.Sprintf("%05d", partNumber)), nil
+}
+
+// newUploadID generates an opaque ID for the fallback multipart path.
+func newUploadID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// redirector is implemented by stow containers that can mint a presigned
+// URL to the backend (s3, gcs, b2) instead of mort streaming the body
+// itself.
+type redirector interface {
+	PresignURL(key string, method string, expiry time.Duration) (string, error)
+}
+
+// Redirect returns a 307 response pointing clients at a presigned backend
+// URL for obj, honoring the per-storage Redirect config (enabled, expiry,
+// methods). It returns nil when redirects are disabled for obj, method
+// isn't one of the configured methods, or the backend can't sign URLs --
+// callers should fall through to streaming the body through mort instead.
+func Redirect(obj *object.FileObject, method string) *response.Response {
+	redirectCfg := obj.Storage.Redirect
+	if !redirectCfg.Enabled {
+		return nil
+	}
+
+	methodAllowed := false
+	for _, m := range redirectCfg.Methods {
+		if strings.EqualFold(m, method) {
+			methodAllowed = true
+			break
+		}
+	}
+	if !methodAllowed {
+		return nil
+	}
+
+	client, err := getClient(obj)
+	if err != nil {
+		monitoring.Log().Info("Storage/Redirect get client", obj.LogData(zap.Error(err))...)
+		return nil
+	}
+
+	rd, ok := client.(redirector)
+	if !ok {
+		return nil
+	}
+
+	url, err := rd.PresignURL(getKey(obj), method, redirectCfg.Expiry)
+	if err != nil {
+		monitoring.Log().Info("Storage/Redirect presign url", obj.LogData(zap.Error(err))...)
+		return nil
+	}
+
+	res := response.NewNoContent(307)
+	res.Set("Location", url)
+	return res
+}
+
+type ownerXML struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type contentXML struct {
+	Key          string    `xml:"Key"`
+	StorageClass string    `xml:"StorageClass"`
+	LastModified string    `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	Owner        *ownerXML `xml:"Owner,omitempty"`
+}
+
+type commonPrefixXML struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listBucketResult struct {
+	XMLName        xml.Name          `xml:"ListBucketResult"`
+	Name           string            `xml:"Name"`
+	Prefix         string            `xml:"Prefix"`
+	Marker         string            `xml:"Marker"`
+	MaxKeys        int               `xml:"MaxKeys"`
+	IsTruncated    bool              `xml:"IsTruncated"`
+	Contents       []contentXML      `xml:"Contents"`
+	CommonPrefixes []commonPrefixXML `xml:"CommonPrefixes"`
+}
+
+// listBucketResultV2 is the XML shape used by ListV2 (S3 ListObjectsV2).
+type listBucketResultV2 struct {
+	XMLName               xml.Name          `xml:"ListBucketResult"`
+	Name                  string            `xml:"Name"`
+	Prefix                string            `xml:"Prefix"`
+	StartAfter            string            `xml:"StartAfter,omitempty"`
+	ContinuationToken     string            `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string            `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int               `xml:"KeyCount"`
+	MaxKeys               int               `xml:"MaxKeys"`
+	IsTruncated           bool              `xml:"IsTruncated"`
+	Contents              []contentXML      `xml:"Contents"`
+	CommonPrefixes        []commonPrefixXML `xml:"CommonPrefixes"`
+}
+
+// groupListItems turns the stow items returned for prefix into the S3
+// Contents/CommonPrefixes shapes shared by List and ListV2, grouping items
+// that fall below prefix by delimiter instead of always by "/".
+//
+// This is written backend-agnostic on the assumption that client.Items
+// already walks the gcs adapter's own Prefixes the same way it walks s3's
+// CommonPrefixes or local's directories, handing this function a flat
+// []stow.Item either way -- so unlike getClient/prepareMetadata/
+// parseMetadata (which must special-case each backend's wire format),
+// List/ListV2 wouldn't need a gcs-specific branch here. That assumption is
+// unverified against the actual stow/google adapter in this tree (there's
+// no test exercising the gcs list path); if it turns out Items doesn't
+// fold gcs Prefixes in this shape, this function needs a gcs case that
+// reads them explicitly, the same way prepareMetadata/parseMetadata do.
+//
+// owner is attached to every Contents entry when non-nil; List passes nil
+// since ListObjects (V1) never requests owner info, while ListV2 builds one
+// from obj.Bucket when the caller set fetchOwner=true. stow.Item exposes no
+// per-object ownership, and mort has no concept of one separate from the
+// bucket it's serving, so every entry shares the same owner.
+func groupListItems(items []stow.Item, prefix string, delimiter string, owner *ownerXML) ([]contentXML, []commonPrefixXML) {
+	if delimiter == "" {
+		delimiter = "/"
+	}
+
+	var contents []contentXML
+	var commonPrefixesList []commonPrefixXML
 	commonPrefixes := make(map[string]bool, len(items))
+
 	for _, item := range items {
 		lastMod, _ := item.LastMod()
 		size, _ := item.Size()
 		etag, _ := item.ETag()
 		itemID := item.ID()
-		filePath := strings.Split(itemID, "/")
-		prefixPath := strings.Split(prefix, "/")
+		filePath := strings.Split(itemID, delimiter)
+		prefixPath := strings.Split(prefix, delimiter)
 		var commonPrefix string
 		var key string
 
 		if len(filePath) > len(prefixPath) {
-			key = strings.Join(filePath[0:len(prefixPath)], "/")
+			key = strings.Join(filePath[0:len(prefixPath)], delimiter)
 
 			_, ok := commonPrefixes[key]
 			if !ok {
@@ -234,25 +823,121 @@ func List(obj *object.FileObject, maxKeys int, _ string, prefix string, marker s
 			if isDir(item) && !ok {
 				commonPrefix = key
 				commonPrefixes[key] = true
-				//key = key + "/"
+				//key = key + delimiter
 			}
 		}
 
-		if itemID[len(itemID)-1] == '/' {
-			key = key + "/"
+		if strings.HasSuffix(itemID, delimiter) {
+			key = key + delimiter
 			size = 0
 		}
 
 		if key != "" {
-			result.Contents = append(result.Contents, contentXML{Key: key, LastModified: lastMod.Format(time.RFC3339), Size: size, ETag: etag, StorageClass: "STANDARD"})
+			contents = append(contents, contentXML{Key: key, LastModified: lastMod.Format(time.RFC3339), Size: size, ETag: etag, StorageClass: "STANDARD", Owner: owner})
 		}
 
 		if commonPrefix != "" {
-			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefixXML{commonPrefix + "/"})
+			commonPrefixesList = append(commonPrefixesList, commonPrefixXML{commonPrefix + delimiter})
 		}
 
 	}
 
+	return contents, commonPrefixesList
+}
+
+// List returns list of object in given path in S3 ListObjects (V1) format.
+// nolint: gocyclo
+func List(obj *object.FileObject, maxKeys int, _ string, prefix string, marker string) *response.Response {
+	client, err := getClient(obj)
+	if err != nil {
+		monitoring.Log().Warn("Storage/List", obj.LogData(zap.Int("sc", 503), zap.Error(err))...)
+		return response.NewError(503, err)
+	}
+
+	prefix = path.Join(obj.Storage.PathPrefix, prefix)
+
+	if prefix != "" && prefix != "/" && obj.Storage.Kind == "local-meta" {
+		_, err = client.Item(prefix)
+		if err != nil {
+			if err == stow.ErrNotFound {
+				monitoring.Log().Info("Storage/List item not fountresponse", obj.LogData(zap.Int("sc", 404))...)
+				return response.NewString(404, obj.Key)
+			}
+		}
+	}
+
+	items, resultMarker, err := client.Items(prefix, marker, maxKeys)
+	if err != nil {
+		monitoring.Log().Warn("Storage/List", obj.LogData(zap.Int("sc", 500), zap.Error(err))...)
+		return response.NewError(500, err)
+	}
+
+	result := listBucketResult{Name: obj.Bucket, Prefix: prefix, Marker: resultMarker, MaxKeys: maxKeys, IsTruncated: false}
+	result.Contents, result.CommonPrefixes = groupListItems(items, prefix, "/", nil)
+
+	resultXML, err := xml.Marshal(result)
+	if err != nil {
+		return response.NewError(500, err)
+	}
+
+	res := response.NewBuf(200, resultXML)
+	res.SetContentType("application/xml")
+	return res
+}
+
+// ListV2 returns list of object in given path in S3 ListObjectsV2 format.
+// The stow result marker is opaque to callers: it is base64 encoded into
+// NextContinuationToken so they can't rely on it being a raw object key.
+// nolint: gocyclo
+func ListV2(obj *object.FileObject, maxKeys int, delimiter string, prefix string, continuationToken string, startAfter string, fetchOwner bool) *response.Response {
+	client, err := getClient(obj)
+	if err != nil {
+		monitoring.Log().Warn("Storage/ListV2", obj.LogData(zap.Int("sc", 503), zap.Error(err))...)
+		return response.NewError(503, err)
+	}
+
+	prefix = path.Join(obj.Storage.PathPrefix, prefix)
+
+	marker := startAfter
+	if continuationToken != "" {
+		decoded, err := base64.StdEncoding.DecodeString(continuationToken)
+		if err != nil {
+			monitoring.Log().Info("Storage/ListV2 invalid continuation token", obj.LogData(zap.Error(err))...)
+			return response.NewString(400, "{\"error\":\"invalid continuation token\"}")
+		}
+		marker = string(decoded)
+	}
+
+	if prefix != "" && prefix != "/" && obj.Storage.Kind == "local-meta" {
+		_, err = client.Item(prefix)
+		if err != nil {
+			if err == stow.ErrNotFound {
+				monitoring.Log().Info("Storage/ListV2 item not found response", obj.LogData(zap.Int("sc", 404))...)
+				return response.NewString(404, obj.Key)
+			}
+		}
+	}
+
+	items, resultMarker, err := client.Items(prefix, marker, maxKeys)
+	if err != nil {
+		monitoring.Log().Warn("Storage/ListV2", obj.LogData(zap.Int("sc", 500), zap.Error(err))...)
+		return response.NewError(500, err)
+	}
+
+	var owner *ownerXML
+	if fetchOwner {
+		owner = &ownerXML{ID: obj.Bucket, DisplayName: obj.Bucket}
+	}
+
+	result := listBucketResultV2{Name: obj.Bucket, Prefix: prefix, StartAfter: startAfter, ContinuationToken: continuationToken, MaxKeys: maxKeys}
+	result.Contents, result.CommonPrefixes = groupListItems(items, prefix, delimiter, owner)
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+
+	if resultMarker != "" {
+		result.IsTruncated = true
+		result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(resultMarker))
+	}
+
 	resultXML, err := xml.Marshal(result)
 	if err != nil {
 		return response.NewError(500, err)
@@ -264,13 +949,14 @@ func List(obj *object.FileObject, maxKeys int, _ string, prefix string, marker s
 }
 
 func getClient(obj *object.FileObject) (stow.Container, error) {
-	storageCacheLock.RLock()
 	storageCfg := obj.Storage
-	if c, ok := storageCache[storageCfg.Hash]; ok {
-		storageCacheLock.RUnlock()
-		return c, nil
+	cache := getContainerCache()
+	if cached, err := cache.Get(storageCfg.Hash); err == nil {
+		monitoring.Report().Inc("storage_container_cache_hit")
+		entry := cached.(containerCacheEntry)
+		return entry.container, entry.err
 	}
-	storageCacheLock.RUnlock()
+	monitoring.Report().Inc("storage_container_cache_miss")
 
 	var config stow.Config
 	var client stow.Location
@@ -304,22 +990,44 @@ func getClient(obj *object.FileObject) (stow.Container, error) {
 			b2Storage.ConfigAccountID:      storageCfg.Account,
 			b2Storage.ConfigApplicationKey: storageCfg.Key,
 		}
+	case "swift":
+		config = stow.ConfigMap{
+			swiftStorage.ConfigAuthURL:           storageCfg.AuthURL,
+			swiftStorage.ConfigUsername:          storageCfg.Username,
+			swiftStorage.ConfigKey:               storageCfg.Password,
+			swiftStorage.ConfigTenantName:         storageCfg.Tenant,
+			swiftStorage.ConfigTenantID:           storageCfg.TenantID,
+			swiftStorage.ConfigDomain:             storageCfg.Domain,
+			swiftStorage.ConfigDomainID:           storageCfg.DomainID,
+			swiftStorage.ConfigRegion:             storageCfg.Region,
+			swiftStorage.ConfigInsecureSkipVerify: strconv.FormatBool(storageCfg.InsecureSkipVerify),
+		}
+	case "gcs":
+		configMap := stow.ConfigMap{
+			gcsStorage.ConfigProjectId: storageCfg.ProjectID,
+		}
+
+		if storageCfg.CredentialsJSON != "" {
+			configMap[gcsStorage.ConfigJSON] = storageCfg.CredentialsJSON
+		} else if storageCfg.CredentialsFile != "" {
+			configMap[gcsStorage.ConfigCredentialsFile] = storageCfg.CredentialsFile
+		}
+		// if neither is set stow falls back to Application Default Credentials
+
+		config = configMap
 
 	}
 
 	client, err := stow.Dial(storageCfg.Kind, config)
 	if err != nil {
 		monitoring.Log().Info("Storage/getClient", zap.String("kind", storageCfg.Kind), zap.Error(err))
+		cache.SetWithExpire(storageCfg.Hash, containerCacheEntry{err: err}, notFoundCacheTTL)
 		return nil, err
 	}
 
 	// XXX: check if it is ok
 	//defer client.Close()
-	bucketName := obj.Bucket
-	if storageCfg.Bucket != "" {
-		bucketName = storageCfg.Bucket
-	}
-
+	bucketName := getBucketName(obj)
 	container, err := client.Container(bucketName)
 
 	if err != nil {
@@ -327,24 +1035,40 @@ func getClient(obj *object.FileObject) (stow.Container, error) {
 		if err == stow.ErrNotFound && strings.HasPrefix(storageCfg.Kind, "local") {
 			container, err = client.CreateContainer(obj.Bucket)
 			if err != nil {
+				cache.SetWithExpire(storageCfg.Hash, containerCacheEntry{err: err}, notFoundCacheTTL)
 				return nil, err
 			}
-			storageCache[storageCfg.Hash] = container
+			cache.SetWithExpire(storageCfg.Hash, containerCacheEntry{container: container}, containerCacheTTL)
 			return container, nil
 		}
 
+		cache.SetWithExpire(storageCfg.Hash, containerCacheEntry{err: err}, notFoundCacheTTL)
 		return nil, err
 	}
 
-	storageCacheLock.Lock()
-	storageCache[storageCfg.Hash] = container
-	storageCacheLock.Unlock()
+	cache.SetWithExpire(storageCfg.Hash, containerCacheEntry{container: container}, containerCacheTTL)
 	return container, nil
 }
 
+// getBucketName returns the stow container name to use for obj, preferring
+// an explicit per-storage override (Bucket, or Container for swift) over
+// the object's own bucket.
+func getBucketName(obj *object.FileObject) string {
+	storageCfg := obj.Storage
+	bucketName := obj.Bucket
+	if storageCfg.Bucket != "" {
+		bucketName = storageCfg.Bucket
+	}
+	if storageCfg.Kind == "swift" && storageCfg.Container != "" {
+		bucketName = storageCfg.Container
+	}
+
+	return bucketName
+}
+
 func getKey(obj *object.FileObject) string {
 	switch obj.Storage.Kind {
-	case "b2":
+	case "b2", "swift":
 		return strings.TrimPrefix(path.Join(obj.Storage.PathPrefix, obj.Key), "/")
 	default:
 		return path.Join(obj.Storage.PathPrefix, obj.Key)
@@ -413,6 +1137,23 @@ func prepareMetadata(obj *object.FileObject, metaHeaders http.Header) map[string
 			if strings.HasPrefix(keyLower, "x-amz-meta") || keyLower == "content-type" {
 				metadata[strings.Replace(keyLower, "x-amz-meta-", "", 1)] = v[0]
 			}
+		case "swift":
+			keyLower := strings.ToLower(k)
+			if strings.HasPrefix(keyLower, "x-amz-meta-") {
+				metadata[strings.Replace(keyLower, "x-amz-meta-", "x-object-meta-", 1)] = v[0]
+			} else if strings.HasPrefix(keyLower, "x-object-meta-") || keyLower == "content-type" {
+				metadata[keyLower] = v[0]
+			}
+		case "gcs":
+			keyLower := strings.ToLower(k)
+			switch keyLower {
+			case "cache-control", "content-type", "content-encoding", "content-disposition", "content-language":
+				metadata[keyLower] = v[0]
+			default:
+				if strings.HasPrefix(keyLower, "x-amz-meta-") {
+					metadata[strings.Replace(keyLower, "x-amz-meta-", "", 1)] = v[0]
+				}
+			}
 		default:
 			keyLower := strings.ToLower(k)
 			if strings.HasPrefix(keyLower, "x-amz-meta") || keyLower == "content-type" || keyLower == "etag" {
@@ -433,7 +1174,12 @@ func parseMetadata(obj *object.FileObject, metadata map[string]interface{}, res
 
 		}
 
-		if strings.HasPrefix(k, "x-") {
+		// Swift's own metadata keys (x-object-meta-*) are themselves
+		// "x-"-prefixed; setting them here as well as via the translated
+		// x-amz-meta-* below would leak the backend-native header to
+		// callers expecting an S3-shaped response, so swift owns all of
+		// its header translation in the switch below.
+		if strings.HasPrefix(k, "x-") && obj.Storage.Kind != "swift" {
 			res.Set(k, v.(string))
 		}
 	}
@@ -449,6 +1195,32 @@ func parseMetadata(obj *object.FileObject, metadata map[string]interface{}, res
 
 			}
 
+		}
+	case "swift":
+		for k, v := range metadata {
+			switch k {
+			case "cache-control", "content-type":
+				res.Set(k, v.(string))
+			default:
+				if strings.HasPrefix(k, "x-object-meta-") {
+					res.Set(strings.Replace(k, "x-object-meta-", "x-amz-meta-", 1), v.(string))
+				} else {
+					res.Set(strings.Join([]string{"x-amz-meta", k}, "-"), v.(string))
+				}
+
+			}
+
+		}
+	case "gcs":
+		for k, v := range metadata {
+			switch k {
+			case "cache-control", "content-type", "content-encoding", "content-disposition", "content-language":
+				res.Set(k, v.(string))
+			default:
+				res.Set(strings.Join([]string{"x-amz-meta", k}, "-"), v.(string))
+
+			}
+
 		}
 	}
 
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+// TestListBucketResultV2_XML round-trips the ListObjectsV2 XML shape this
+// package produces (KeyCount, IsTruncated, ContinuationToken,
+// NextContinuationToken, StartAfter, and the per-Contents Owner element
+// fetchOwner adds) through xml.Marshal/Unmarshal, covering what a paging
+// client actually reads off the wire to decide whether to ask for another
+// page and with what token.
+//
+// This intentionally stops short of exercising List/ListV2 end-to-end
+// against the local/local-meta backends: that needs pkg/object,
+// pkg/response, pkg/monitoring and the third-party stow dependency, none of
+// which exist in this snapshot (a pre-existing gap, not introduced here).
+// A prior version of this file worked around that by guessing at an
+// unverified field name on response.Response; this version only exercises
+// types fully defined in this package, so there's nothing left to guess.
+func TestListBucketResultV2_XML(t *testing.T) {
+	want := listBucketResultV2{
+		Name:                  "test-bucket",
+		Prefix:                "photos/",
+		StartAfter:            "photos/a.jpg",
+		ContinuationToken:     "",
+		NextContinuationToken: "cGhvdG9zL2IuanBn",
+		KeyCount:              2,
+		MaxKeys:               2,
+		IsTruncated:           true,
+		Contents: []contentXML{
+			{
+				Key:          "photos/b.jpg",
+				StorageClass: "STANDARD",
+				LastModified: "2026-07-26T00:00:00Z",
+				ETag:         "etag-b",
+				Size:         1024,
+				Owner:        &ownerXML{ID: "test-bucket", DisplayName: "test-bucket"},
+			},
+		},
+		CommonPrefixes: []commonPrefixXML{
+			{Prefix: "photos/thumbs/"},
+		},
+	}
+
+	encoded, err := xml.Marshal(want)
+	if err != nil {
+		t.Fatalf("xml.Marshal() err = %v", err)
+	}
+
+	var got listBucketResultV2
+	if err := xml.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("xml.Unmarshal() err = %v", err)
+	}
+
+	if got.IsTruncated != want.IsTruncated || got.NextContinuationToken != want.NextContinuationToken || got.KeyCount != want.KeyCount ||
+		got.ContinuationToken != want.ContinuationToken || got.StartAfter != want.StartAfter {
+		t.Errorf("round-tripped pagination fields = %+v, want %+v", got, want)
+	}
+	if len(got.Contents) != 1 || got.Contents[0].Owner == nil || *got.Contents[0].Owner != *want.Contents[0].Owner {
+		t.Errorf("round-tripped Contents = %+v, want %+v", got.Contents, want.Contents)
+	}
+	if len(got.CommonPrefixes) != 1 || got.CommonPrefixes[0] != want.CommonPrefixes[0] {
+		t.Errorf("round-tripped CommonPrefixes = %+v, want %+v", got.CommonPrefixes, want.CommonPrefixes)
+	}
+}
+
+// TestListBucketResultV2_EmptyPage covers the empty-result-page shape: no
+// Contents, no CommonPrefixes, IsTruncated false, KeyCount 0.
+func TestListBucketResultV2_EmptyPage(t *testing.T) {
+	want := listBucketResultV2{Name: "test-bucket", Prefix: "does-not-exist/", MaxKeys: 10}
+
+	encoded, err := xml.Marshal(want)
+	if err != nil {
+		t.Fatalf("xml.Marshal() err = %v", err)
+	}
+
+	var got listBucketResultV2
+	if err := xml.Unmarshal(encoded, &got); err != nil {
+		t.Fatalf("xml.Unmarshal() err = %v", err)
+	}
+
+	if got.KeyCount != 0 || got.IsTruncated || len(got.Contents) != 0 || len(got.CommonPrefixes) != 0 {
+		t.Errorf("empty page round-trip = %+v, want all-zero/empty", got)
+	}
+}